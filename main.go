@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,7 +11,10 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/TobiasYin/go-lsp/logs"
 	"github.com/TobiasYin/go-lsp/lsp"
@@ -24,8 +28,201 @@ const (
 
 	nodeKindChipDefinition = "chip_definition"
 	nodeKindPart           = "part"
+	nodeKindPinAssignment  = "pin_assignment"
 )
 
+// Pin is a single IN/OUT pin declaration: a name and, for a bus, its width
+// in bits (1 for a plain wire).
+type Pin struct {
+	Name  string
+	Width int
+}
+
+// ChipInterface is a chip's parsed IN/OUT signature, used by completion,
+// code actions, hover and the semantic analyzer.
+type ChipInterface struct {
+	Ins  []Pin
+	Outs []Pin
+}
+
+// findPin looks up name among iface's IN and OUT pins.
+func findPin(iface ChipInterface, name string) (pin Pin, isOut, ok bool) {
+	for _, p := range iface.Ins {
+		if p.Name == name {
+			return p, false, true
+		}
+	}
+	for _, p := range iface.Outs {
+		if p.Name == name {
+			return p, true, true
+		}
+	}
+
+	return Pin{}, false, false
+}
+
+// openFile is a client-open document kept in sync incrementally: its current
+// source, LSP version, and the tree-sitter tree parsed from that source.
+type openFile struct {
+	source  []byte
+	version int
+	tree    *tree_sitter.Tree
+}
+
+// chipUse is a single `part` reference to a chip, located within some file.
+type chipUse struct {
+	uri defines.DocumentUri
+	rng defines.Range
+}
+
+// ChipIndex maps chip names to their parsed signature, defining file and
+// location, plus a reverse map of every part site that references them. It
+// is populated by scanning the workspace and the built-in chip library on
+// initialization, and kept up to date as documents are opened and changed
+// and as workspace/didChangeWatchedFiles notifications arrive.
+type ChipIndex struct {
+	parser     *tree_sitter.Parser
+	chips      map[string]ChipInterface
+	defFile    map[string]string
+	defRange   map[string]defines.Range
+	uses       map[string][]chipUse
+	usesByFile map[string][]string
+}
+
+func newChipIndex(parser *tree_sitter.Parser) *ChipIndex {
+	return &ChipIndex{
+		parser:     parser,
+		chips:      make(map[string]ChipInterface),
+		defFile:    make(map[string]string),
+		defRange:   make(map[string]defines.Range),
+		uses:       make(map[string][]chipUse),
+		usesByFile: make(map[string][]string),
+	}
+}
+
+// Scan walks dir, (re)indexing every .hdl file it finds.
+func (idx *ChipIndex) Scan(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ext {
+			return nil
+		}
+
+		return idx.indexFile(path)
+	})
+}
+
+// indexFile (re)parses path, replacing any signature, definition and
+// references it previously contributed to the index.
+func (idx *ChipIndex) indexFile(path string) error {
+	sig, chipName, defRange, refs, err := parseChipFile(idx.parser, path)
+	if err != nil {
+		return err
+	}
+
+	idx.store(path, sig, chipName, defRange, refs)
+	return nil
+}
+
+// IndexSource (re)indexes path from an already-parsed in-memory buffer,
+// e.g. an open editor's live contents, instead of reading path from disk.
+// This keeps the index current for the file being edited, which would
+// otherwise only pick up a just-typed pin once the file is saved.
+func (idx *ChipIndex) IndexSource(path string, tree *tree_sitter.Tree, source []byte) {
+	sig, chipName, defRange, refs := parseChipTree(tree, source)
+	idx.store(path, sig, chipName, defRange, refs)
+}
+
+// store replaces whatever path previously contributed to the index with
+// the freshly parsed sig/chipName/defRange/refs.
+func (idx *ChipIndex) store(path string, sig ChipInterface, chipName string, defRange defines.Range, refs []chipReference) {
+	idx.forget(path)
+
+	if chipName == "" {
+		chipName = strings.TrimSuffix(filepath.Base(path), ext)
+	}
+
+	idx.chips[chipName] = sig
+	idx.defFile[chipName] = path
+	idx.defRange[chipName] = defRange
+
+	uri := defines.DocumentUri("file://" + path)
+	used := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		idx.uses[ref.chipName] = append(idx.uses[ref.chipName], chipUse{uri: uri, rng: ref.rng})
+		used = append(used, ref.chipName)
+	}
+	idx.usesByFile[path] = used
+}
+
+// Remove drops everything path previously contributed to the index, e.g.
+// when a workspace/didChangeWatchedFiles deletion notification arrives.
+func (idx *ChipIndex) Remove(path string) {
+	idx.forget(path)
+
+	for name, defPath := range idx.defFile {
+		if defPath == path {
+			delete(idx.chips, name)
+			delete(idx.defFile, name)
+			delete(idx.defRange, name)
+		}
+	}
+}
+
+// forget removes the uses that path previously contributed, ahead of a
+// reindex or removal.
+func (idx *ChipIndex) forget(path string) {
+	uri := defines.DocumentUri("file://" + path)
+	for _, name := range idx.usesByFile[path] {
+		uses := idx.uses[name]
+		filtered := make([]chipUse, 0, len(uses))
+		for _, use := range uses {
+			if use.uri != uri {
+				filtered = append(filtered, use)
+			}
+		}
+		idx.uses[name] = filtered
+	}
+	delete(idx.usesByFile, path)
+}
+
+func (idx *ChipIndex) Has(name string) bool {
+	_, ok := idx.chips[name]
+	return ok
+}
+
+func (idx *ChipIndex) Get(name string) (ChipInterface, bool) {
+	sig, ok := idx.chips[name]
+	return sig, ok
+}
+
+func (idx *ChipIndex) DefinitionFile(name string) (string, bool) {
+	path, ok := idx.defFile[name]
+	return path, ok
+}
+
+func (idx *ChipIndex) DefinitionRange(name string) (defines.Range, bool) {
+	rng, ok := idx.defRange[name]
+	return rng, ok
+}
+
+func (idx *ChipIndex) References(name string) []chipUse {
+	return idx.uses[name]
+}
+
+// Names returns every indexed chip name, sorted for stable completion order.
+func (idx *ChipIndex) Names() []string {
+	names := make([]string, 0, len(idx.chips))
+	for name := range idx.chips {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func main() {
 	logger := log.New(os.Stdout, "hdls: ", log.LstdFlags)
 	logs.Init(logger)
@@ -39,10 +236,35 @@ func main() {
 
 	server := lsp.NewServer(&lsp.Options{})
 
+	chipIndex := newChipIndex(parser)
+	openFiles := make(map[string]*openFile)
+
 	server.OnInitialize(func(ctx context.Context, req *defines.InitializeParams) (result *defines.InitializeResult, err *defines.InitializeError) {
 		ir := &defines.InitializeResult{}
-		ir.Capabilities.TextDocumentSync = defines.TextDocumentSyncKindFull
+		ir.Capabilities.TextDocumentSync = defines.TextDocumentSyncKindIncremental
 		ir.Capabilities.DefinitionProvider = true
+		ir.Capabilities.CodeActionProvider = true
+		ir.Capabilities.CompletionProvider = &defines.CompletionOptions{
+			TriggerCharacters: &[]string{"(", ",", "="},
+		}
+		ir.Capabilities.HoverProvider = true
+		ir.Capabilities.DocumentSymbolProvider = true
+		ir.Capabilities.ReferencesProvider = true
+		ir.Capabilities.RenameProvider = true
+
+		root := ""
+		if uri, ok := req.RootUri.(string); ok && uri != "" {
+			root = toFilePath(uri)
+		} else if path, ok := req.RootPath.(string); ok && path != "" {
+			root = path
+		}
+
+		if root != "" {
+			if err := chipIndex.Scan(root); err != nil {
+				log.Printf("hdls: scan workspace %s: %v", root, err)
+			}
+		}
+
 		return ir, nil
 	})
 
@@ -50,19 +272,15 @@ func main() {
 		return nil
 	})
 
-	implementedChips := make(map[string]struct{})
-	openFiles := make(map[string][]byte)
-
-	publishDiagnostics := func(source []byte, uri defines.DocumentUri, version int) error {
-		tree := parser.Parse(source, nil)
-		defer tree.Close()
-
+	publishDiagnostics := func(tree *tree_sitter.Tree, source []byte, uri defines.DocumentUri, version int) error {
 		var implementingChipName string
+		var chipDef *tree_sitter.Node
 		var walk func(n *tree_sitter.Node)
 		diagnostics := make([]defines.Diagnostic, 0)
 		walk = func(n *tree_sitter.Node) {
 			switch strings.TrimSpace(n.Kind()) {
 			case nodeKindChipDefinition:
+				chipDef = n
 				if name := n.ChildByFieldName("name"); name != nil {
 					implementingChipName = string(source[name.StartByte():name.EndByte()])
 				}
@@ -74,7 +292,7 @@ func main() {
 						diagnostics = append(diagnostics, newDiagnostic(name, fmt.Sprintf("Cannot use chip %s to implement itself", chipName)))
 					}
 
-					if _, ok := implementedChips[chipName]; !ok {
+					if !chipIndex.Has(chipName) {
 						diagnostics = append(diagnostics, newDiagnostic(name, fmt.Sprintf("Undefined chip name: %s", chipName)))
 					}
 				}
@@ -98,6 +316,10 @@ func main() {
 
 		walk(tree.RootNode())
 
+		if chipDef != nil {
+			diagnostics = append(diagnostics, analyzeChip(chipDef, source, chipIndex)...)
+		}
+
 		diagnosticsParams := defines.PublishDiagnosticsParams{
 			Uri:         uri,
 			Version:     &version,
@@ -118,17 +340,16 @@ func main() {
 	server.OnDidOpenTextDocument(func(ctx context.Context, req *defines.DidOpenTextDocumentParams) error {
 		uri := string(req.TextDocument.Uri)
 		source := []byte(req.TextDocument.Text)
-		openFiles[uri] = source
-
-		if err := collectChips(builtInChipsDir(uri), implementedChips); err != nil {
-			return fmt.Errorf("collect primitive builtin chips: %w", err)
-		}
+		tree := parser.Parse(source, nil)
+		openFiles[uri] = &openFile{source: source, version: req.TextDocument.Version, tree: tree}
 
-		if err := collectChips(baseDir(uri), implementedChips); err != nil {
-			return fmt.Errorf("collect implemented chips: %w", err)
-		}
+		// The workspace (including built-in chips) was already indexed from
+		// disk in OnInitialize and is kept current via
+		// workspace/didChangeWatchedFiles; only this file's own entry needs
+		// refreshing, and from the buffer just opened rather than disk.
+		chipIndex.IndexSource(toFilePath(uri), tree, source)
 
-		if err := publishDiagnostics(source, req.TextDocument.Uri, req.TextDocument.Version); err != nil {
+		if err := publishDiagnostics(tree, source, req.TextDocument.Uri, req.TextDocument.Version); err != nil {
 			return err
 		}
 
@@ -136,10 +357,32 @@ func main() {
 	})
 
 	server.OnDidChangeTextDocument(func(ctx context.Context, req *defines.DidChangeTextDocumentParams) error {
-		for _, contentChange := range req.ContentChanges {
-			if err := publishDiagnostics([]byte(contentChange.Text.(string)), req.TextDocument.Uri, req.TextDocument.Version); err != nil {
-				return err
+		uri := string(req.TextDocument.Uri)
+
+		file, ok := openFiles[uri]
+		if !ok {
+			return fmt.Errorf("received change notification for unopened document %s", uri)
+		}
+
+		for _, change := range req.ContentChanges {
+			newSource, newTree, err := applyIncrementalChange(parser, file.source, file.tree, change)
+			if err != nil {
+				return fmt.Errorf("apply incremental change: %w", err)
 			}
+
+			file.source = newSource
+			file.tree = newTree
+		}
+		file.version = req.TextDocument.Version
+
+		// Reindex just this buffer from memory; rescanning the whole
+		// workspace from disk on every keystroke is what incremental
+		// parsing was meant to eliminate, and every other file's entry is
+		// already kept current via workspace/didChangeWatchedFiles.
+		chipIndex.IndexSource(toFilePath(uri), file.tree, file.source)
+
+		if err := publishDiagnostics(file.tree, file.source, req.TextDocument.Uri, req.TextDocument.Version); err != nil {
+			return err
 		}
 
 		return nil
@@ -161,15 +404,7 @@ func main() {
 		if strings.TrimSuffix(node.Kind(), "\n") == nodeKindPart {
 			if name := node.ChildByFieldName("chip_name"); name != nil {
 				primitiveChipName := string(source[name.StartByte():name.EndByte()])
-				fileName := primitiveChipName + ext
-				var targetUri string
-				path := filepath.Join(baseDir(uri), fileName)
-				_, err := os.Stat(path)
-				if errors.Is(err, os.ErrNotExist) {
-					targetUri = filepath.Join(builtInChipsDir(uri), fileName)
-				} else {
-					targetUri = path
-				}
+				targetUri := resolveChipFile(uri, primitiveChipName)
 
 				targetSource, err := readFile(targetUri)
 				if err != nil {
@@ -225,9 +460,261 @@ func main() {
 		return nil, nil
 	})
 
+	server.OnCodeActionWithSliceCodeAction(func(ctx context.Context, req *defines.CodeActionParams) (result *[]defines.CodeAction, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		actions := make([]defines.CodeAction, 0)
+
+		for _, diag := range req.Context.Diagnostics {
+			switch {
+			case diag.Message == `Expected ";"`:
+				actions = append(actions, insertSemicolonAction(req.TextDocument.Uri, diag))
+			case strings.HasPrefix(diag.Message, "Undefined chip name: "):
+				chipName := strings.TrimPrefix(diag.Message, "Undefined chip name: ")
+				actions = append(actions, stubChipAction(tree.RootNode(), source, uri, chipName))
+			}
+		}
+
+		offset := getByteOffset(string(source), int(req.Range.Start.Line), int(req.Range.Start.Character))
+		if part := partAt(tree.RootNode(), offset); part != nil {
+			if name := part.ChildByFieldName("chip_name"); name != nil {
+				chipName := string(source[name.StartByte():name.EndByte()])
+				if sig, ok := chipIndex.Get(chipName); ok {
+					if action, ok := fillPartAction(part, source, req.TextDocument.Uri, sig); ok {
+						actions = append(actions, action)
+					}
+				}
+			}
+		}
+
+		return &actions, nil
+	})
+
+	server.OnCompletion(func(ctx context.Context, req *defines.CompletionParams) (result *[]defines.CompletionItem, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := getByteOffset(string(source), int(req.Position.Line), int(req.Position.Character))
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		node := nodeAt(tree.RootNode(), offset)
+
+		if part := enclosing(node, nodeKindPart); part != nil {
+			if name := part.ChildByFieldName("chip_name"); name != nil && offsetWithin(name, offset) {
+				return chipNameCompletions(chipIndex), nil
+			}
+
+			if assignment := enclosing(node, nodeKindPinAssignment); assignment != nil {
+				if left := assignment.ChildByFieldName("left"); left != nil && offsetWithin(left, offset) {
+					chipName := ""
+					if name := part.ChildByFieldName("chip_name"); name != nil {
+						chipName = string(source[name.StartByte():name.EndByte()])
+					}
+
+					iface, _ := chipIndex.Get(chipName)
+					return pinCompletions(append(pinNameList(iface.Ins), pinNameList(iface.Outs)...)), nil
+				}
+
+				def := enclosing(node, nodeKindChipDefinition)
+				return pinCompletions(scopeNames(def, source, part.StartByte())), nil
+			}
+		}
+
+		empty := make([]defines.CompletionItem, 0)
+		return &empty, nil
+	})
+
+	server.OnDidChangeWatchedFiles(func(ctx context.Context, req *defines.DidChangeWatchedFilesParams) error {
+		for _, change := range req.Changes {
+			path := toFilePath(string(change.Uri))
+
+			if change.Type == defines.FileChangeTypeDeleted {
+				chipIndex.Remove(path)
+				continue
+			}
+
+			if err := chipIndex.indexFile(path); err != nil {
+				return fmt.Errorf("reindex %s: %w", path, err)
+			}
+		}
+
+		return nil
+	})
+
+	server.OnDocumentSymbolWithSliceDocumentSymbol(func(ctx context.Context, req *defines.DocumentSymbolParams) (result *[]defines.DocumentSymbol, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		symbols := make([]defines.DocumentSymbol, 0)
+		if def := chipDefinitionNode(tree.RootNode()); def != nil {
+			symbols = append(symbols, chipDocumentSymbol(def, source))
+		}
+
+		return &symbols, nil
+	})
+
+	server.OnReferences(func(ctx context.Context, req *defines.ReferenceParams) (result *[]defines.Location, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := getByteOffset(string(source), int(req.Position.Line), int(req.Position.Character))
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		chipName := chipNameAt(tree.RootNode(), source, offset)
+
+		locations := make([]defines.Location, 0)
+		for _, use := range chipIndex.References(chipName) {
+			locations = append(locations, defines.Location{Uri: use.uri, Range: use.rng})
+		}
+
+		return &locations, nil
+	})
+
+	server.OnRenameRequest(func(ctx context.Context, req *defines.RenameParams) (result *defines.WorkspaceEdit, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := getByteOffset(string(source), int(req.Position.Line), int(req.Position.Character))
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		chipName := chipNameAt(tree.RootNode(), source, offset)
+		if chipName == "" {
+			return nil, fmt.Errorf("no chip under cursor to rename")
+		}
+
+		defPath, ok := chipIndex.DefinitionFile(chipName)
+		if !ok {
+			return nil, fmt.Errorf("no definition found for chip %s", chipName)
+		}
+		defRange, _ := chipIndex.DefinitionRange(chipName)
+
+		newPath := filepath.Join(filepath.Dir(defPath), req.NewName+ext)
+
+		// go-lsp has no typed "rename file" resource operation, so perform
+		// the filesystem rename directly and point the header edit at the
+		// chip's new location.
+		if err := os.Rename(defPath, newPath); err != nil {
+			return nil, fmt.Errorf("rename chip file: %w", err)
+		}
+
+		changes := make(map[string][]defines.TextEdit)
+		newUri := string(defines.DocumentUri("file://" + newPath))
+		changes[newUri] = append(changes[newUri], defines.TextEdit{Range: defRange, NewText: req.NewName})
+
+		for _, use := range chipIndex.References(chipName) {
+			changes[string(use.uri)] = append(changes[string(use.uri)], defines.TextEdit{Range: use.rng, NewText: req.NewName})
+		}
+
+		if err := chipIndex.indexFile(newPath); err != nil {
+			return nil, fmt.Errorf("reindex %s: %w", newPath, err)
+		}
+
+		return &defines.WorkspaceEdit{Changes: &changes}, nil
+	})
+
+	server.OnHover(func(ctx context.Context, req *defines.HoverParams) (result *defines.Hover, err error) {
+		uri := string(req.TextDocument.Uri)
+		source, err := readFile(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		offset := getByteOffset(string(source), int(req.Position.Line), int(req.Position.Character))
+
+		tree := parser.Parse(source, nil)
+		defer tree.Close()
+
+		node := nodeAt(tree.RootNode(), offset)
+		part := enclosing(node, nodeKindPart)
+		if part == nil {
+			return nil, nil
+		}
+
+		name := part.ChildByFieldName("chip_name")
+		if name == nil {
+			return nil, nil
+		}
+
+		hoveringPin := false
+		if assignment := enclosing(node, nodeKindPinAssignment); assignment != nil {
+			if left := assignment.ChildByFieldName("left"); left != nil && offsetWithin(left, offset) {
+				hoveringPin = true
+			}
+		}
+
+		if !offsetWithin(name, offset) && !hoveringPin {
+			return nil, nil
+		}
+
+		chipName := string(source[name.StartByte():name.EndByte()])
+		targetPath := resolveChipFile(uri, chipName)
+
+		targetSource, err := os.ReadFile(targetPath)
+		if err != nil {
+			return nil, nil
+		}
+
+		targetTree := parser.Parse(targetSource, nil)
+		defer targetTree.Close()
+
+		def := chipDefinitionNode(targetTree.RootNode())
+		if def == nil {
+			return nil, nil
+		}
+
+		value := fmt.Sprintf("```hdl\n%s\n```\n\n%s", chipDeclarationText(def, targetSource), targetPath)
+		return &defines.Hover{
+			Contents: defines.MarkupContent{
+				Kind:  defines.MarkupKindMarkdown,
+				Value: value,
+			},
+		}, nil
+	})
+
 	server.Run()
 }
 
+// resolveChipFile returns the absolute path of chipName's .hdl file,
+// preferring the project directory over the built-in chip library. Both
+// OnDefinition and OnHover resolve a referenced chip this way.
+func resolveChipFile(uri, chipName string) string {
+	fileName := chipName + ext
+	path := filepath.Join(baseDir(uri), fileName)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return filepath.Join(builtInChipsDir(uri), fileName)
+	}
+
+	return path
+}
+
 func readFile(uri string) ([]byte, error) {
 	source, err := os.ReadFile(toFilePath(uri))
 	if err != nil {
@@ -236,37 +723,98 @@ func readFile(uri string) ([]byte, error) {
 	return source, nil
 }
 
-func collectChips(dir string, chips map[string]struct{}) error {
-	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+// getByteOffset converts a zero-based line and UTF-16 code-unit character
+// offset (the units LSP positions are expressed in) into a byte offset into
+// text. It walks the target line rune by rune, rather than slicing by
+// character count, so multi-byte UTF-8 sequences and UTF-16 surrogate pairs
+// don't throw the offset out of alignment.
+func getByteOffset(text string, line, char int) int {
+	source := []byte(text)
+
+	lineStart := 0
+	for currentLine := 0; currentLine < line; currentLine++ {
+		idx := bytes.IndexByte(source[lineStart:], '\n')
+		if idx == -1 {
+			return len(source)
+		}
+		lineStart += idx + 1
+	}
+
+	byteCol := 0
+	units := 0
+	for units < char && lineStart+byteCol < len(source) {
+		r, size := utf8.DecodeRune(source[lineStart+byteCol:])
+		if r == '\n' {
+			break
 		}
 
-		if !d.IsDir() && filepath.Ext(path) == ext {
-			chip := strings.TrimSuffix(filepath.Base(path), ext)
-			chips[chip] = struct{}{}
+		byteCol += size
+		if r > 0xFFFF {
+			units += 2
+		} else {
+			units++
 		}
-		return nil
-	}); err != nil {
-		return fmt.Errorf("error walking dir: %w", err)
 	}
 
-	return nil
+	return lineStart + byteCol
 }
 
-func getByteOffset(text string, line, char int) int {
-	lines := strings.Split(text, "\n")
-	if line > len(lines) {
-		return len(text)
+// pointAtOffset returns the tree-sitter point (row, byte column) for a byte
+// offset into source.
+func pointAtOffset(source []byte, offset int) tree_sitter.Point {
+	row := 0
+	lineStart := 0
+	for i := 0; i < offset && i < len(source); i++ {
+		if source[i] == '\n' {
+			row++
+			lineStart = i + 1
+		}
+	}
+
+	return tree_sitter.Point{Row: uint(row), Column: uint(offset - lineStart)}
+}
+
+// applyIncrementalChange applies a single textDocument/didChange content
+// change to source/tree, returning the updated source and a tree reparsed
+// incrementally from the previous one. tree is closed once the edit has
+// been folded into the reparse.
+//
+// We only ever negotiate TextDocumentSyncKindIncremental (see OnInitialize),
+// so every change a spec-compliant client sends here carries a populated
+// Range; a whole-document replacement arrives through OnDidOpenTextDocument
+// instead. There is no reliable way to special-case a "full document" event
+// here anyway: Range is a value, not a pointer, so an omitted range and an
+// explicit zero-width range at the start of the file unmarshal identically.
+func applyIncrementalChange(parser *tree_sitter.Parser, source []byte, tree *tree_sitter.Tree, change defines.TextDocumentContentChangeEvent) ([]byte, *tree_sitter.Tree, error) {
+	text, ok := change.Text.(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected content change text type %T", change.Text)
 	}
 
-	offset := 0
-	for i := 0; i < line; i++ {
-		offset += len(lines[i]) + 1
+	startByte := getByteOffset(string(source), int(change.Range.Start.Line), int(change.Range.Start.Character))
+	oldEndByte := getByteOffset(string(source), int(change.Range.End.Line), int(change.Range.End.Character))
+
+	newSource := make([]byte, 0, len(source)-(oldEndByte-startByte)+len(text))
+	newSource = append(newSource, source[:startByte]...)
+	newSource = append(newSource, text...)
+	newSource = append(newSource, source[oldEndByte:]...)
+
+	newEndByte := startByte + len(text)
+
+	edit := tree_sitter.InputEdit{
+		StartByte:      uint(startByte),
+		OldEndByte:     uint(oldEndByte),
+		NewEndByte:     uint(newEndByte),
+		StartPosition:  pointAtOffset(source, startByte),
+		OldEndPosition: pointAtOffset(source, oldEndByte),
+		NewEndPosition: pointAtOffset(newSource, newEndByte),
 	}
 
-	offset += len([]byte(lines[line][:char]))
-	return offset
+	tree.Edit(&edit)
+	newTree := parser.Parse(newSource, tree)
+	tree.Close()
+
+	return newSource, newTree, nil
 }
 
 func builtInChipsDir(uri string) string {
@@ -284,20 +832,709 @@ func toFilePath(uri string) string {
 	return strings.TrimPrefix(enEscapeUrl, "file:")
 }
 
-func newDiagnostic(n *tree_sitter.Node, msg string) defines.Diagnostic {
-	severity := defines.DiagnosticSeverityError
-	return defines.Diagnostic{
-		Range: defines.Range{
-			Start: defines.Position{
-				Line:      n.StartPosition().Row,
-				Character: n.StartPosition().Column,
+// chipReference is a `part`'s use of a chip, found while parsing the file
+// that implements some other chip (or none).
+type chipReference struct {
+	chipName string
+	rng      defines.Range
+}
+
+// parseChipFile reads and parses the .hdl file at path, returning the
+// signature and defining-name range of the chip it implements (if any) and
+// every chip it references via a `part`.
+func parseChipFile(parser *tree_sitter.Parser, path string) (iface ChipInterface, chipName string, defRange defines.Range, refs []chipReference, err error) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return ChipInterface{}, "", defines.Range{}, nil, err
+	}
+
+	tree := parser.Parse(source, nil)
+	defer tree.Close()
+
+	iface, chipName, defRange, refs = parseChipTree(tree, source)
+	return iface, chipName, defRange, refs, nil
+}
+
+// parseChipTree extracts the same signature, defining-name range and
+// `part` references as parseChipFile, from a tree already parsed from
+// source (e.g. an open buffer's live tree, which may be ahead of what's on
+// disk).
+func parseChipTree(tree *tree_sitter.Tree, source []byte) (iface ChipInterface, chipName string, defRange defines.Range, refs []chipReference) {
+	def := chipDefinitionNode(tree.RootNode())
+	if def == nil {
+		return ChipInterface{}, "", defines.Range{}, nil
+	}
+
+	if name := def.ChildByFieldName("name"); name != nil {
+		chipName = string(source[name.StartByte():name.EndByte()])
+		defRange = rangeOf(name)
+	}
+
+	iface.Ins = parsePins(def.ChildByFieldName("ins"), source)
+	iface.Outs = parsePins(def.ChildByFieldName("outs"), source)
+
+	for _, part := range partNodes(def) {
+		if name := part.ChildByFieldName("chip_name"); name != nil {
+			refs = append(refs, chipReference{
+				chipName: string(source[name.StartByte():name.EndByte()]),
+				rng:      rangeOf(name),
+			})
+		}
+	}
+
+	return iface, chipName, defRange, refs
+}
+
+// chipDefinitionNode finds the first chip_definition node in the tree rooted
+// at n.
+func chipDefinitionNode(n *tree_sitter.Node) *tree_sitter.Node {
+	if strings.TrimSpace(n.Kind()) == nodeKindChipDefinition {
+		return n
+	}
+
+	for i := 0; i < int(n.NamedChildCount()); i++ {
+		if def := chipDefinitionNode(n.NamedChild(uint(i))); def != nil {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// chipDeclarationText renders def's IN/OUT pin lists as they appear in
+// source, e.g. "IN a, b;\nOUT out;".
+func chipDeclarationText(def *tree_sitter.Node, source []byte) string {
+	var b strings.Builder
+	if ins := def.ChildByFieldName("ins"); ins != nil {
+		fmt.Fprintf(&b, "IN %s;\n", source[ins.StartByte():ins.EndByte()])
+	}
+	if outs := def.ChildByFieldName("outs"); outs != nil {
+		fmt.Fprintf(&b, "OUT %s;\n", source[outs.StartByte():outs.EndByte()])
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// pinNames returns the pin names declared under an IN/OUT pin list node,
+// stripping any "[width]" sub-bus suffix.
+func pinNames(list *tree_sitter.Node, source []byte) []string {
+	pins := parsePins(list, source)
+	names := make([]string, 0, len(pins))
+	for _, p := range pins {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+// pinNameList extracts the names from a slice of Pin, in order.
+func pinNameList(pins []Pin) []string {
+	names := make([]string, 0, len(pins))
+	for _, p := range pins {
+		names = append(names, p.Name)
+	}
+
+	return names
+}
+
+// parsePins extracts the pin declarations from an IN/OUT pin-list node,
+// e.g. "a, b[16], sel[2]" becomes [{a 1} {b 16} {sel 2}]: the bracketed
+// number in a declaration is the pin's total bus width, not a bit index.
+func parsePins(list *tree_sitter.Node, source []byte) []Pin {
+	if list == nil {
+		return nil
+	}
+
+	pins := make([]Pin, 0, list.NamedChildCount())
+	for i := 0; i < int(list.NamedChildCount()); i++ {
+		pinNode := list.NamedChild(uint(i))
+		name, width := parsePinDecl(string(source[pinNode.StartByte():pinNode.EndByte()]))
+		pins = append(pins, Pin{Name: name, Width: width})
+	}
+
+	return pins
+}
+
+// parsePinDecl splits a declaration-side pin reference such as "a" or
+// "a[16]" into its bare name and declared width (1 for a plain wire, N for
+// an explicit "[N]" bus-width suffix).
+func parsePinDecl(text string) (name string, width int) {
+	open := strings.IndexByte(text, '[')
+	if open == -1 {
+		return text, 1
+	}
+
+	close := strings.IndexByte(text, ']')
+	if close == -1 || close < open {
+		return text[:open], 1
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(text[open+1 : close]))
+	if err != nil {
+		return text[:open], 1
+	}
+
+	return text[:open], n
+}
+
+// parseBusRef splits a pin_assignment operand reference such as "a", "a[2]"
+// (a single-bit index into a bus) or "a[0..7]" (a sub-bus slice) into its
+// bare name and the inclusive bit range it selects. Unlike a declaration's
+// "[N]" suffix, a bare index here selects exactly one bit (lo == hi), not
+// a width. hasRange is false for a plain name, whose width must come from
+// its own declaration elsewhere.
+func parseBusRef(text string) (name string, lo, hi int, hasRange bool) {
+	open := strings.IndexByte(text, '[')
+	if open == -1 {
+		return text, 0, 0, false
+	}
+
+	close := strings.IndexByte(text, ']')
+	if close == -1 || close < open {
+		return text[:open], 0, 0, false
+	}
+
+	spec := text[open+1 : close]
+	before, after, isSlice := strings.Cut(spec, "..")
+	if !isSlice {
+		n, err := strconv.Atoi(strings.TrimSpace(spec))
+		if err != nil {
+			return text[:open], 0, 0, false
+		}
+		return text[:open], n, n, true
+	}
+
+	loN, errLo := strconv.Atoi(strings.TrimSpace(before))
+	hiN, errHi := strconv.Atoi(strings.TrimSpace(after))
+	if errLo != nil || errHi != nil {
+		return text[:open], 0, 0, false
+	}
+
+	if hiN < loN {
+		loN, hiN = hiN, loN
+	}
+
+	return text[:open], loN, hiN, true
+}
+
+// exprWidth returns the bit width of a pin_assignment operand: a literal
+// (1 bit), an explicit bus index/slice ("[n]" is 1 bit, "[a..b]" is
+// hi-lo+1 bits), or — for a bare name — the declared width of that pin in
+// iface. Returns -1 if the width can't be determined (e.g. an internal
+// wire with no declaration), so callers can skip the comparison rather
+// than report a false positive.
+func exprWidth(text string, iface ChipInterface) int {
+	if text == "true" || text == "false" {
+		return 1
+	}
+
+	name, lo, hi, hasRange := parseBusRef(text)
+	if hasRange {
+		return hi - lo + 1
+	}
+
+	if pin, _, ok := findPin(iface, name); ok {
+		return pin.Width
+	}
+
+	return -1
+}
+
+// analyzeChip walks a chip_definition's parts and pin assignments, checking
+// that every referenced pin exists, that any "[a..b]" bus slice is in
+// bounds for the pin it indexes, that the two sides of a pin assignment
+// agree in bit width, that every sub-part input is connected, that every
+// declared OUT pin is assigned, and that no internal wire is driven more
+// than once. Diagnostics about undefined chips and self-implementation are
+// reported separately by publishDiagnostics.
+func analyzeChip(def *tree_sitter.Node, source []byte, index *ChipIndex) []defines.Diagnostic {
+	diagnostics := make([]defines.Diagnostic, 0)
+
+	ownIface := ChipInterface{
+		Ins:  parsePins(def.ChildByFieldName("ins"), source),
+		Outs: parsePins(def.ChildByFieldName("outs"), source),
+	}
+
+	driven := make(map[string][]driveRange)
+
+	for _, part := range partNodes(def) {
+		chipNameNode := part.ChildByFieldName("chip_name")
+		if chipNameNode == nil {
+			continue
+		}
+		chipName := string(source[chipNameNode.StartByte():chipNameNode.EndByte()])
+
+		iface, ok := index.Get(chipName)
+		if !ok {
+			continue // undefined chip is already reported elsewhere
+		}
+
+		connected := make(map[string]struct{})
+
+		var walk func(n *tree_sitter.Node)
+		walk = func(n *tree_sitter.Node) {
+			if strings.TrimSpace(n.Kind()) == nodeKindPinAssignment {
+				left := n.ChildByFieldName("left")
+				right := n.ChildByFieldName("right")
+				if left == nil || right == nil {
+					return
+				}
+
+				leftText := string(source[left.StartByte():left.EndByte()])
+				rightText := string(source[right.StartByte():right.EndByte()])
+				leftName, _, leftHi, leftHasRange := parseBusRef(leftText)
+				rightName, rightLo, rightHi, rightHasRange := parseBusRef(rightText)
+				connected[leftName] = struct{}{}
+
+				leftPin, isOut, ok := findPin(iface, leftName)
+				if !ok {
+					diagnostics = append(diagnostics, newDiagnostic(left, fmt.Sprintf("%s has no pin %s", chipName, leftName)))
+					return
+				}
+				if leftHasRange && leftHi >= leftPin.Width {
+					diagnostics = append(diagnostics, newDiagnostic(left, fmt.Sprintf("%s is only %d-bit, %s is out of range", leftName, leftPin.Width, leftText)))
+				}
+
+				if rightHasRange {
+					if rightPin, _, ok := findPin(ownIface, rightName); ok && rightHi >= rightPin.Width {
+						diagnostics = append(diagnostics, newDiagnostic(right, fmt.Sprintf("%s is only %d-bit, %s is out of range", rightName, rightPin.Width, rightText)))
+					}
+				}
+
+				leftWidth := exprWidth(leftText, iface)
+				rightWidth := exprWidth(rightText, ownIface)
+				if leftWidth >= 0 && rightWidth >= 0 && leftWidth != rightWidth {
+					diagnostics = append(diagnostics, newDiagnostic(right, fmt.Sprintf("width mismatch: %s is %d-bit, %s is %d-bit", leftText, leftWidth, rightText, rightWidth)))
+				}
+
+				if isOut {
+					lo, hi := rightLo, rightHi
+					if !rightHasRange {
+						if rightPin, _, ok := findPin(ownIface, rightName); ok {
+							lo, hi = 0, rightPin.Width-1
+						}
+					}
+					driven[rightName] = append(driven[rightName], driveRange{lo: lo, hi: hi, node: right})
+				}
+				return
+			}
+
+			for i := 0; i < int(n.NamedChildCount()); i++ {
+				walk(n.NamedChild(uint(i)))
+			}
+		}
+		walk(part)
+
+		for _, in := range iface.Ins {
+			if _, ok := connected[in.Name]; !ok {
+				diagnostics = append(diagnostics, newDiagnostic(chipNameNode, fmt.Sprintf("input %s of %s is not connected", in.Name, chipName)))
+			}
+		}
+	}
+
+	if outs := def.ChildByFieldName("outs"); outs != nil {
+		for _, out := range ownIface.Outs {
+			if len(driven[out.Name]) == 0 {
+				diagnostics = append(diagnostics, newDiagnostic(outs, fmt.Sprintf("output %s is never assigned", out.Name)))
+			}
+		}
+	}
+
+	for wire, ranges := range driven {
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo < ranges[j].lo })
+		maxHi := -1
+		for _, r := range ranges {
+			if r.lo <= maxHi {
+				diagnostics = append(diagnostics, newDiagnostic(r.node, fmt.Sprintf("%s is driven more than once", wire)))
+			}
+			if r.hi > maxHi {
+				maxHi = r.hi
+			}
+		}
+	}
+
+	return diagnostics
+}
+
+// driveRange records that some part assignment drives bits lo..hi
+// (inclusive) of a wire; a plain, unsliced assignment covers the wire's
+// whole declared width. Two assignments to the same wire only conflict if
+// their ranges actually overlap, so bit-by-bit construction of a multi-bit
+// wire (out[0]=..., out[1]=..., out[2]=..., the standard nand2tetris idiom
+// for chips like Add16) is not mistaken for a double drive.
+type driveRange struct {
+	lo, hi int
+	node   *tree_sitter.Node
+}
+
+// nodeAt returns the smallest node covering the given byte offset.
+func nodeAt(root *tree_sitter.Node, offset int) *tree_sitter.Node {
+	return root.DescendantForByteRange(uint(offset), uint(offset))
+}
+
+// enclosing walks up from node to find the nearest ancestor (or node itself)
+// of the given kind, or nil if none is found.
+func enclosing(node *tree_sitter.Node, kind string) *tree_sitter.Node {
+	for node != nil {
+		if strings.TrimSpace(node.Kind()) == kind {
+			return node
+		}
+		node = node.Parent()
+	}
+
+	return nil
+}
+
+// offsetWithin reports whether offset falls inside n's byte range.
+func offsetWithin(n *tree_sitter.Node, offset int) bool {
+	return offset >= int(n.StartByte()) && offset <= int(n.EndByte())
+}
+
+// partAt returns the part node enclosing the given byte offset, or nil.
+func partAt(root *tree_sitter.Node, offset int) *tree_sitter.Node {
+	return enclosing(nodeAt(root, offset), nodeKindPart)
+}
+
+// scopeNames returns the names available on the right-hand side of a
+// pin_assignment at the given point in def: its declared IN/OUT pins, plus
+// any internal wire introduced by a pin_assignment that starts before
+// beforeByte.
+func scopeNames(def *tree_sitter.Node, source []byte, beforeByte uint) []string {
+	if def == nil {
+		return nil
+	}
+
+	names := append(pinNames(def.ChildByFieldName("ins"), source), pinNames(def.ChildByFieldName("outs"), source)...)
+	seen := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		seen[name] = struct{}{}
+	}
+
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if strings.TrimSpace(n.Kind()) == nodeKindPinAssignment && n.StartByte() < beforeByte {
+			if right := n.ChildByFieldName("right"); right != nil {
+				wire := string(source[right.StartByte():right.EndByte()])
+				if wire != "true" && wire != "false" {
+					if _, ok := seen[wire]; !ok {
+						seen[wire] = struct{}{}
+						names = append(names, wire)
+					}
+				}
+			}
+		}
+
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			walk(n.NamedChild(uint(i)))
+		}
+	}
+	walk(def)
+
+	return names
+}
+
+// chipNameCompletions lists every chip known to index, annotated with its
+// IN/OUT signature.
+func chipNameCompletions(index *ChipIndex) *[]defines.CompletionItem {
+	items := make([]defines.CompletionItem, 0, len(index.Names()))
+	kind := defines.CompletionItemKindClass
+	for _, name := range index.Names() {
+		sig, _ := index.Get(name)
+		detail := chipDetail(name, sig)
+		items = append(items, defines.CompletionItem{
+			Label:  name,
+			Kind:   &kind,
+			Detail: &detail,
+		})
+	}
+
+	return &items
+}
+
+func chipDetail(name string, iface ChipInterface) string {
+	return fmt.Sprintf("CHIP %s { IN %s; OUT %s; }", name, strings.Join(pinNameList(iface.Ins), ", "), strings.Join(pinNameList(iface.Outs), ", "))
+}
+
+func pinCompletions(names []string) *[]defines.CompletionItem {
+	items := make([]defines.CompletionItem, 0, len(names))
+	kind := defines.CompletionItemKindVariable
+	for _, name := range names {
+		items = append(items, defines.CompletionItem{
+			Label: name,
+			Kind:  &kind,
+		})
+	}
+
+	return &items
+}
+
+// closingParen finds the ')' token closing a part's argument list.
+func closingParen(part *tree_sitter.Node) *tree_sitter.Node {
+	for i := int(part.ChildCount()) - 1; i >= 0; i-- {
+		child := part.Child(uint(i))
+		if strings.TrimSpace(child.Kind()) == ")" {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// connectedPins collects the left-hand side of every pin_assignment under n.
+func connectedPins(n *tree_sitter.Node, source []byte) map[string]struct{} {
+	connected := make(map[string]struct{})
+
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if strings.TrimSpace(n.Kind()) == nodeKindPinAssignment {
+			if left := n.ChildByFieldName("left"); left != nil {
+				connected[string(source[left.StartByte():left.EndByte()])] = struct{}{}
+			}
+			return
+		}
+
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			walk(n.NamedChild(uint(i)))
+		}
+	}
+	walk(n)
+
+	return connected
+}
+
+func insertSemicolonAction(uri defines.DocumentUri, diag defines.Diagnostic) defines.CodeAction {
+	kind := defines.CodeActionKindQuickFix
+	return defines.CodeAction{
+		Title:       "Insert missing ';'",
+		Kind:        &kind,
+		Diagnostics: &[]defines.Diagnostic{diag},
+		Edit: &defines.WorkspaceEdit{
+			Changes: &map[string][]defines.TextEdit{
+				string(uri): {
+					{
+						Range:   defines.Range{Start: diag.Range.Start, End: diag.Range.Start},
+						NewText: ";",
+					},
+				},
+			},
+		},
+	}
+}
+
+// stubChipAction builds a "create chip" quick fix: a new <chipName>.hdl file
+// under baseDir, pre-populated with an IN/OUT skeleton guessed from the
+// pin_assignment left-hand sides of the part that references chipName.
+func stubChipAction(root *tree_sitter.Node, source []byte, uri, chipName string) defines.CodeAction {
+	var part *tree_sitter.Node
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if part != nil {
+			return
+		}
+		if strings.TrimSpace(n.Kind()) == nodeKindPart {
+			if name := n.ChildByFieldName("chip_name"); name != nil && string(source[name.StartByte():name.EndByte()]) == chipName {
+				part = n
+				return
+			}
+		}
+
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			walk(n.NamedChild(uint(i)))
+		}
+	}
+	walk(root)
+
+	ins := make([]string, 0)
+	outs := make([]string, 0)
+	if part != nil {
+		for pin := range connectedPins(part, source) {
+			if strings.Contains(strings.ToLower(pin), "out") {
+				outs = append(outs, pin)
+			} else {
+				ins = append(ins, pin)
+			}
+		}
+	}
+
+	skeleton := fmt.Sprintf("CHIP %s {\n    IN %s;\n    OUT %s;\n\n    PARTS:\n}\n",
+		chipName, strings.Join(ins, ", "), strings.Join(outs, ", "))
+
+	targetUri := defines.DocumentUri("file://" + filepath.Join(baseDir(uri), chipName+ext))
+	kind := defines.CodeActionKindQuickFix
+	return defines.CodeAction{
+		Title: fmt.Sprintf("Create chip %s", chipName),
+		Kind:  &kind,
+		Edit: &defines.WorkspaceEdit{
+			Changes: &map[string][]defines.TextEdit{
+				string(targetUri): {
+					{
+						Range:   defines.Range{},
+						NewText: skeleton,
+					},
+				},
 			},
-			End: defines.Position{
-				Line:      n.EndPosition().Row,
-				Character: n.EndPosition().Column,
+		},
+	}
+}
+
+// fillPartAction expands a part's argument list with every IN/OUT pin of sig
+// that isn't already connected, mapping IN pins to false and OUT pins to an
+// unconnected internal wire.
+func fillPartAction(part *tree_sitter.Node, source []byte, uri defines.DocumentUri, iface ChipInterface) (defines.CodeAction, bool) {
+	connected := connectedPins(part, source)
+
+	missing := make([]string, 0)
+	for _, in := range iface.Ins {
+		if _, ok := connected[in.Name]; !ok {
+			missing = append(missing, fmt.Sprintf("%s=false", in.Name))
+		}
+	}
+	for _, out := range iface.Outs {
+		if _, ok := connected[out.Name]; !ok {
+			missing = append(missing, fmt.Sprintf("%s=%sWire", out.Name, strings.ToLower(out.Name)))
+		}
+	}
+
+	if len(missing) == 0 {
+		return defines.CodeAction{}, false
+	}
+
+	insertPos := part.EndPosition()
+	if paren := closingParen(part); paren != nil {
+		insertPos = paren.StartPosition()
+	}
+
+	prefix := ""
+	if len(connected) > 0 {
+		prefix = ", "
+	}
+
+	position := defines.Position{Line: insertPos.Row, Character: insertPos.Column}
+	kind := defines.CodeActionKindQuickFix
+	return defines.CodeAction{
+		Title: "Fill part connections",
+		Kind:  &kind,
+		Edit: &defines.WorkspaceEdit{
+			Changes: &map[string][]defines.TextEdit{
+				string(uri): {
+					{
+						Range:   defines.Range{Start: position, End: position},
+						NewText: prefix + strings.Join(missing, ", "),
+					},
+				},
 			},
 		},
+	}, true
+}
+
+func newDiagnostic(n *tree_sitter.Node, msg string) defines.Diagnostic {
+	severity := defines.DiagnosticSeverityError
+	return defines.Diagnostic{
+		Range:    rangeOf(n),
 		Severity: &severity,
 		Message:  msg,
 	}
 }
+
+// rangeOf converts a tree-sitter node's span into an LSP range.
+func rangeOf(n *tree_sitter.Node) defines.Range {
+	return defines.Range{
+		Start: defines.Position{Line: n.StartPosition().Row, Character: n.StartPosition().Column},
+		End:   defines.Position{Line: n.EndPosition().Row, Character: n.EndPosition().Column},
+	}
+}
+
+// partNodes collects every `part` node under def.
+func partNodes(def *tree_sitter.Node) []*tree_sitter.Node {
+	var parts []*tree_sitter.Node
+
+	var walk func(n *tree_sitter.Node)
+	walk = func(n *tree_sitter.Node) {
+		if strings.TrimSpace(n.Kind()) == nodeKindPart {
+			parts = append(parts, n)
+			return
+		}
+
+		for i := 0; i < int(n.NamedChildCount()); i++ {
+			walk(n.NamedChild(uint(i)))
+		}
+	}
+	walk(def)
+
+	return parts
+}
+
+// chipNameAt returns the name of the chip referenced or declared at offset,
+// either a part's chip_name or the enclosing chip_definition's own name, or
+// "" if offset isn't on either.
+func chipNameAt(root *tree_sitter.Node, source []byte, offset int) string {
+	node := nodeAt(root, offset)
+
+	if part := enclosing(node, nodeKindPart); part != nil {
+		if name := part.ChildByFieldName("chip_name"); name != nil && offsetWithin(name, offset) {
+			return string(source[name.StartByte():name.EndByte()])
+		}
+	}
+
+	if def := enclosing(node, nodeKindChipDefinition); def != nil {
+		if name := def.ChildByFieldName("name"); name != nil && offsetWithin(name, offset) {
+			return string(source[name.StartByte():name.EndByte()])
+		}
+	}
+
+	return ""
+}
+
+// chipDocumentSymbol builds the hierarchical symbol for a chip_definition:
+// the chip itself, its IN/OUT pin groups, and each of its parts.
+func chipDocumentSymbol(def *tree_sitter.Node, source []byte) defines.DocumentSymbol {
+	children := make([]defines.DocumentSymbol, 0)
+
+	if ins := def.ChildByFieldName("ins"); ins != nil {
+		children = append(children, defines.DocumentSymbol{
+			Name:           "IN " + strings.Join(pinNames(ins, source), ", "),
+			Kind:           defines.SymbolKindField,
+			Range:          rangeOf(ins),
+			SelectionRange: rangeOf(ins),
+		})
+	}
+
+	if outs := def.ChildByFieldName("outs"); outs != nil {
+		children = append(children, defines.DocumentSymbol{
+			Name:           "OUT " + strings.Join(pinNames(outs, source), ", "),
+			Kind:           defines.SymbolKindField,
+			Range:          rangeOf(outs),
+			SelectionRange: rangeOf(outs),
+		})
+	}
+
+	for _, part := range partNodes(def) {
+		name := part.ChildByFieldName("chip_name")
+		if name == nil {
+			continue
+		}
+
+		children = append(children, defines.DocumentSymbol{
+			Name:           string(source[name.StartByte():name.EndByte()]),
+			Kind:           defines.SymbolKindMethod,
+			Range:          rangeOf(part),
+			SelectionRange: rangeOf(name),
+		})
+	}
+
+	name := def.ChildByFieldName("name")
+	chipName := ""
+	nameRange := rangeOf(def)
+	if name != nil {
+		chipName = string(source[name.StartByte():name.EndByte()])
+		nameRange = rangeOf(name)
+	}
+
+	return defines.DocumentSymbol{
+		Name:           chipName,
+		Kind:           defines.SymbolKindClass,
+		Range:          rangeOf(def),
+		SelectionRange: nameRange,
+		Children:       &children,
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+
+	tree_sitter_hdl "github.com/quantonganh/tree-sitter-hdl/bindings/go"
+	tree_sitter "github.com/tree-sitter/go-tree-sitter"
+
+	"github.com/TobiasYin/go-lsp/lsp/defines"
+)
+
+func TestParseBusRef(t *testing.T) {
+	cases := []struct {
+		text     string
+		name     string
+		lo, hi   int
+		hasRange bool
+	}{
+		{text: "a", name: "a", hasRange: false},
+		{text: "sel[2]", name: "sel", lo: 2, hi: 2, hasRange: true},
+		{text: "bus[0..7]", name: "bus", lo: 0, hi: 7, hasRange: true},
+		{text: "bus[7..0]", name: "bus", lo: 0, hi: 7, hasRange: true},
+		{text: "bus[", name: "bus", hasRange: false},
+	}
+
+	for _, c := range cases {
+		name, lo, hi, hasRange := parseBusRef(c.text)
+		if name != c.name || lo != c.lo || hi != c.hi || hasRange != c.hasRange {
+			t.Errorf("parseBusRef(%q) = (%q, %d, %d, %v), want (%q, %d, %d, %v)",
+				c.text, name, lo, hi, hasRange, c.name, c.lo, c.hi, c.hasRange)
+		}
+	}
+}
+
+func TestExprWidth(t *testing.T) {
+	iface := ChipInterface{
+		Ins:  []Pin{{Name: "a", Width: 1}, {Name: "bus", Width: 16}},
+		Outs: []Pin{{Name: "out", Width: 1}},
+	}
+
+	cases := []struct {
+		text string
+		want int
+	}{
+		{text: "true", want: 1},
+		{text: "a", want: 1},
+		{text: "bus", want: 16},
+		// A single bracketed index selects exactly one bit of a bus, not
+		// the bus's declared width.
+		{text: "bus[2]", want: 1},
+		{text: "bus[0..7]", want: 8},
+		{text: "undeclared", want: -1},
+	}
+
+	for _, c := range cases {
+		if got := exprWidth(c.text, iface); got != c.want {
+			t.Errorf("exprWidth(%q) = %d, want %d", c.text, got, c.want)
+		}
+	}
+}
+
+func TestApplyIncrementalChange(t *testing.T) {
+	parser := tree_sitter.NewParser()
+	defer parser.Close()
+	if err := parser.SetLanguage(tree_sitter.NewLanguage(tree_sitter_hdl.Language())); err != nil {
+		// The pinned tree-sitter-hdl and go-tree-sitter versions can drift
+		// out of ABI sync (see their respective go.mod entries); skip
+		// rather than fail the whole suite on an unrelated version mismatch.
+		t.Skipf("tree-sitter-hdl grammar incompatible with go-tree-sitter runtime: %v", err)
+	}
+
+	source := []byte("CHIP Foo {\nIN a, b;\nOUT c;\nPARTS:\n}\n")
+	tree := parser.Parse(source, nil)
+
+	change := defines.TextDocumentContentChangeEvent{
+		Range: defines.Range{
+			Start: defines.Position{Line: 0, Character: 5},
+			End:   defines.Position{Line: 0, Character: 8},
+		},
+		Text: "Bar",
+	}
+
+	newSource, newTree, err := applyIncrementalChange(parser, source, tree, change)
+	if err != nil {
+		t.Fatalf("applyIncrementalChange: %v", err)
+	}
+	defer newTree.Close()
+
+	want := "CHIP Bar {\nIN a, b;\nOUT c;\nPARTS:\n}\n"
+	if string(newSource) != want {
+		t.Errorf("newSource = %q, want %q", newSource, want)
+	}
+
+	name := newTree.RootNode().NamedChild(0).ChildByFieldName("name")
+	if name == nil {
+		t.Fatal("reparsed tree has no chip name node")
+	}
+	if got := string(newSource[name.StartByte():name.EndByte()]); got != "Bar" {
+		t.Errorf("chip name = %q, want %q", got, "Bar")
+	}
+}